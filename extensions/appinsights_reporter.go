@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/Sirupsen/logrus"
+)
+
+// AppInsightsReporter is a FeedbackReporter that sends a TrackEvent to
+// Azure Application Insights for every push notification response.
+type AppInsightsReporter struct {
+	client *appinsights.TelemetryClient
+	Logger *logrus.Logger
+}
+
+// NewAppInsightsReporter creates a new AppInsightsReporter
+func NewAppInsightsReporter(instrumentationKey string, logger *logrus.Logger) *AppInsightsReporter {
+	return &AppInsightsReporter{
+		client: appinsights.NewTelemetryClient(instrumentationKey),
+		Logger: logger,
+	}
+}
+
+// SendNotification tracks msg, a JSON-encoded push response, as an
+// Application Insights event with token/game/platform/apnsID/reason/
+// latencyMs properties.
+func (a *AppInsightsReporter) SendNotification(msg []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return err
+	}
+
+	event := appinsights.NewEventTelemetry("push_notification_response")
+	for _, key := range []string{"token", "game", "platform", "apnsID", "reason", "latencyMs"} {
+		if v, ok := fields[key]; ok {
+			event.Properties[key] = fmt.Sprintf("%v", v)
+		}
+	}
+	a.client.Track(event)
+
+	return nil
+}
+
+// Ping always returns nil; it does not check whether the telemetry client's
+// channel is accepting events.
+func (a *AppInsightsReporter) Ping() error {
+	return nil
+}
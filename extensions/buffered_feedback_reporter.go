@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// BufferedFeedbackReporter wraps an interfaces.FeedbackReporter with a
+// bounded in-memory queue, so a slow sink backs up and drops its own
+// notifications instead of back-pressuring the APNS response handler that
+// calls SendNotification.
+type BufferedFeedbackReporter struct {
+	Logger    *logrus.Logger
+	Name      string
+	reporter  interfaces.FeedbackReporter
+	queue     chan []byte
+	DropCount uint64
+
+	// closeMu guards against Flush closing queue while a SendNotification
+	// call is still in flight: Flush takes the write lock (so it waits for
+	// every in-flight SendNotification, each holding the read lock, to
+	// finish) before closing the channel.
+	closeMu sync.RWMutex
+	closed  bool
+	drained chan struct{}
+}
+
+// NewBufferedFeedbackReporter creates a BufferedFeedbackReporter wrapping
+// reporter with a queue of the given size and starts draining it.
+func NewBufferedFeedbackReporter(name string, reporter interfaces.FeedbackReporter, bufferSize int, logger *logrus.Logger) *BufferedFeedbackReporter {
+	b := &BufferedFeedbackReporter{
+		Logger:   logger,
+		Name:     name,
+		reporter: reporter,
+		queue:    make(chan []byte, bufferSize),
+		drained:  make(chan struct{}),
+	}
+	go b.drain()
+	return b
+}
+
+func (b *BufferedFeedbackReporter) drain() {
+	defer close(b.drained)
+	for msg := range b.queue {
+		if err := b.reporter.SendNotification(msg); err != nil {
+			b.Logger.WithError(err).WithField("reporter", b.Name).Error("failed to send notification to feedback reporter")
+		}
+	}
+}
+
+// SendNotification enqueues msg for the wrapped reporter. If the queue is
+// full the notification is dropped and DropCount is incremented instead of
+// blocking the caller. Once Flush has been called, msg is dropped the same
+// way instead of panicking on a closed channel.
+func (b *BufferedFeedbackReporter) SendNotification(msg []byte) error {
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+
+	if b.closed {
+		atomic.AddUint64(&b.DropCount, 1)
+		return nil
+	}
+
+	select {
+	case b.queue <- msg:
+	default:
+		atomic.AddUint64(&b.DropCount, 1)
+		b.Logger.WithField("reporter", b.Name).Warn("feedback reporter buffer full, dropping notification")
+	}
+	return nil
+}
+
+// Ping reports whether the wrapped reporter is reachable, when it supports
+// that check.
+func (b *BufferedFeedbackReporter) Ping() error {
+	if p, ok := b.reporter.(pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+// Flush stops accepting new notifications, drains whatever is left in the
+// queue, stops the background goroutine and flushes the wrapped reporter if
+// it supports it. Call it once no more notifications will be enqueued, e.g.
+// during graceful shutdown or before discarding a reporter that was
+// replaced by a hot config reload. It waits for any SendNotification call
+// already in flight before closing the queue, so it never races a send
+// against a closed channel.
+func (b *BufferedFeedbackReporter) Flush() {
+	b.closeMu.Lock()
+	if b.closed {
+		b.closeMu.Unlock()
+		return
+	}
+	b.closed = true
+	b.closeMu.Unlock()
+
+	close(b.queue)
+	<-b.drained
+
+	if f, ok := b.reporter.(flusher); ok {
+		f.Flush()
+	}
+}
+
+type pinger interface {
+	Ping() error
+}
+
+// flusher is implemented by wrapped reporters that buffer spans/metrics/
+// notifications of their own and need to drain or shut them down once
+// BufferedFeedbackReporter itself is flushed.
+type flusher interface {
+	Flush()
+}
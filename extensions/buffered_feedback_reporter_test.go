@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+)
+
+type noopFeedbackReporter struct{}
+
+func (noopFeedbackReporter) SendNotification(msg []byte) error { return nil }
+
+// blockingFeedbackReporter blocks every send until release is closed, so the
+// drain goroutine can hold at most one notification.
+type blockingFeedbackReporter struct{ release chan struct{} }
+
+func (r blockingFeedbackReporter) SendNotification(msg []byte) error {
+	<-r.release
+	return nil
+}
+
+var _ = Describe("BufferedFeedbackReporter", func() {
+	logger, _ := test.NewNullLogger()
+
+	Describe("[Unit]", func() {
+		It("drops notifications instead of blocking when the queue is full", func() {
+			reporter := blockingFeedbackReporter{release: make(chan struct{})}
+			defer close(reporter.release)
+			b := NewBufferedFeedbackReporter("test", reporter, 0, logger)
+			// With a zero-size queue the drain goroutine can take at most one
+			// notification before it blocks in the reporter, so of two sends
+			// at least one finds the queue full.
+			Expect(b.SendNotification([]byte("msg"))).To(Succeed())
+			Expect(b.SendNotification([]byte("msg"))).To(Succeed())
+			Expect(b.DropCount).To(BeNumerically(">=", uint64(1)))
+		})
+
+		It("does not panic when SendNotification races with Flush", func() {
+			b := NewBufferedFeedbackReporter("test", noopFeedbackReporter{}, 100, logger)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b.SendNotification([]byte("msg"))
+				}()
+			}
+
+			Expect(func() {
+				b.Flush()
+				wg.Wait()
+			}).NotTo(Panic())
+		})
+
+		It("Flush is idempotent", func() {
+			b := NewBufferedFeedbackReporter("test", noopFeedbackReporter{}, 10, logger)
+			b.Flush()
+			Expect(func() { b.Flush() }).NotTo(Panic())
+		})
+	})
+})
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// sendToFeedbackReporters marshals res and fans it out to every feedback
+// reporter concurrently. A slow or failing reporter is isolated from the
+// others: each send runs in its own goroutine and its error is discarded
+// (neither returned nor logged here), so one bad sink can't block or fail the
+// rest. It returns once every send has returned.
+func sendToFeedbackReporters(feedbackReporters []interfaces.FeedbackReporter, res interface{}) error {
+	jsonRes, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(feedbackReporters))
+	for _, reporter := range feedbackReporters {
+		go func(r interfaces.FeedbackReporter) {
+			defer wg.Done()
+			r.SendNotification(jsonRes)
+		}(reporter)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func handleInvalidToken(invalidTokenHandlers []interfaces.InvalidTokenHandler, token string) {
+	for _, handler := range invalidTokenHandlers {
+		handler.HandleToken(token)
+	}
+}
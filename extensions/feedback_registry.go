@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"sync/atomic"
+
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// FeedbackReporterRegistry holds the current set of feedback reporters
+// behind an atomic pointer, so it can be swapped out live (e.g. on a
+// `feedback.reporters` KV config hot reload) and every subsequent
+// SendNotification call - including ones already in flight in the message
+// handler - picks up the new set on its next Get(), instead of the handler
+// keeping a stale slice captured at construction time.
+type FeedbackReporterRegistry struct {
+	v atomic.Value // []interfaces.FeedbackReporter
+}
+
+// NewFeedbackReporterRegistry creates a registry holding reporters.
+func NewFeedbackReporterRegistry(reporters []interfaces.FeedbackReporter) *FeedbackReporterRegistry {
+	r := &FeedbackReporterRegistry{}
+	r.v.Store(reporters)
+	return r
+}
+
+// Get returns the current set of feedback reporters.
+func (r *FeedbackReporterRegistry) Get() []interfaces.FeedbackReporter {
+	v := r.v.Load()
+	if v == nil {
+		return nil
+	}
+	return v.([]interfaces.FeedbackReporter)
+}
+
+// Set swaps in reporters and returns the ones that were replaced, so the
+// caller can Flush/close them instead of leaking their background
+// goroutines and client connections.
+func (r *FeedbackReporterRegistry) Set(reporters []interfaces.FeedbackReporter) []interfaces.FeedbackReporter {
+	old := r.Get()
+	r.v.Store(reporters)
+	return old
+}
+
+// Send marshals res and fans it out to whatever reporters are current at
+// the time of the call.
+func (r *FeedbackReporterRegistry) Send(res interface{}) error {
+	return sendToFeedbackReporters(r.Get(), res)
+}
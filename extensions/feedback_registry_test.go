@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+var _ = Describe("FeedbackReporterRegistry", func() {
+	Describe("[Unit]", func() {
+		It("Get returns the reporters passed to New", func() {
+			reporters := []interfaces.FeedbackReporter{noopFeedbackReporter{}}
+			r := NewFeedbackReporterRegistry(reporters)
+			Expect(r.Get()).To(Equal(reporters))
+		})
+
+		It("Set swaps in the new reporters and returns the replaced ones", func() {
+			oldReporters := []interfaces.FeedbackReporter{noopFeedbackReporter{}}
+			r := NewFeedbackReporterRegistry(oldReporters)
+
+			newReporters := []interfaces.FeedbackReporter{noopFeedbackReporter{}, noopFeedbackReporter{}}
+			replaced := r.Set(newReporters)
+
+			Expect(replaced).To(Equal(oldReporters))
+			Expect(r.Get()).To(Equal(newReporters))
+		})
+
+		It("a concurrent Send sees whichever generation was current at call time without panicking", func() {
+			r := NewFeedbackReporterRegistry([]interfaces.FeedbackReporter{noopFeedbackReporter{}})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 50; i++ {
+					r.Set([]interfaces.FeedbackReporter{noopFeedbackReporter{}})
+				}
+			}()
+
+			Expect(func() {
+				for i := 0; i < 50; i++ {
+					r.Send([]byte("{}"))
+				}
+				<-done
+			}).NotTo(Panic())
+		})
+	})
+})
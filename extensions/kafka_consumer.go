@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/Sirupsen/logrus"
+	cluster "github.com/bsm/sarama-cluster"
+	"github.com/spf13/viper"
+
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// kafkaMessageSource is the subset of *cluster.Consumer's API that
+// ConsumeLoop and watchRebalances depend on, extracted so tests can drive
+// the pending-queue gating logic with a fake instead of a live Kafka
+// cluster.
+type kafkaMessageSource interface {
+	Messages() <-chan *sarama.ConsumerMessage
+	Errors() <-chan error
+	Notifications() <-chan *cluster.Notification
+	MarkOffset(msg *sarama.ConsumerMessage, metadata string)
+}
+
+// KafkaConsumer for getting push notifications
+type KafkaConsumer struct {
+	Logger            *logrus.Logger
+	Config            *viper.Viper
+	ConsumerGroup     string
+	Brokers           string
+	Topics            []string
+	Consumer          kafkaMessageSource
+	msgChan           chan interfaces.KafkaMessage
+	stopChannel       chan struct{}
+	stopChannelClosed bool
+	pendingMessagesWG *sync.WaitGroup
+	run               bool
+	OffsetsChecker    *ConsumerGroupOffsetsChecker
+}
+
+// NewKafkaConsumer creates a new KafkaConsumer and connects it to the Kafka cluster
+func NewKafkaConsumer(config *viper.Viper, logger *logrus.Logger, clientOrNil ...interfaces.KafkaConsumerClient) (*KafkaConsumer, error) {
+	q := &KafkaConsumer{
+		Config:            config,
+		Logger:            logger,
+		msgChan:           make(chan interfaces.KafkaMessage, 1000),
+		stopChannel:       make(chan struct{}),
+		pendingMessagesWG: &sync.WaitGroup{},
+	}
+	err := q.configure()
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *KafkaConsumer) configure() error {
+	q.ConsumerGroup = q.Config.GetString("kafka.consumerGroup")
+	q.Brokers = q.Config.GetString("kafka.brokers")
+	q.Topics = q.Config.GetStringSlice("kafka.topics")
+
+	consumerConfig := cluster.NewConfig()
+	consumerConfig.Consumer.Return.Errors = true
+	consumerConfig.Group.Return.Notifications = true
+	consumerConfig.Group.PartitionStrategy = cluster.StrategyRoundRobin
+
+	q.OffsetsChecker = NewConsumerGroupOffsetsChecker(q.Logger, q.Brokers, q.ConsumerGroup)
+
+	consumer, err := cluster.NewConsumer([]string{q.Brokers}, q.ConsumerGroup, q.Topics, consumerConfig)
+	if err != nil {
+		return err
+	}
+	q.Consumer = consumer
+
+	go q.watchRebalances()
+
+	return nil
+}
+
+// watchRebalances listens to partition-assignment notifications so the offsets
+// checker can snapshot the committed offsets it must catch up to before the
+// consumer is considered caught up.
+func (q *KafkaConsumer) watchRebalances() {
+	for notification := range q.Consumer.Notifications() {
+		if notification.Type != cluster.RebalanceOK {
+			continue
+		}
+		assigned := map[string][]int32{}
+		for topic, partitions := range notification.Current {
+			for partition := range partitions {
+				assigned[topic] = append(assigned[topic], partition)
+			}
+		}
+		q.OffsetsChecker.SnapshotCommittedOffsets(assigned)
+	}
+}
+
+// ConsumeLoop consumes messages from the queue and forwards them to
+// MessagesChannel, but only once the offsets checker reports the consumer
+// has caught up to the offsets that were committed at assignment time. This
+// closes the event-loss window that exists between a partition being
+// assigned and the handler goroutines being fully wired up. It stops as
+// soon as ctx is canceled or StopConsuming is called.
+//
+// This goroutine is the only one that calls MarkObserved, so it must never
+// block waiting on readiness itself - that would prevent the very
+// observations readiness depends on. Instead, messages read while not ready
+// are held in a local queue and flushed to MessagesChannel as soon as the
+// checker reports readiness. The Kafka offset for a message is only marked
+// once it has actually been forwarded, so a crash while messages are
+// pending never commits an offset for a message that was never delivered.
+func (q *KafkaConsumer) ConsumeLoop(ctx context.Context) error {
+	l := q.Logger.WithFields(logrus.Fields{
+		"method": "ConsumeLoop",
+	})
+
+	var pending []*sarama.ConsumerMessage
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-q.stopChannel:
+			return nil
+		case msg, ok := <-q.Consumer.Messages():
+			if !ok {
+				// The underlying consumer closed Messages() on its own,
+				// without ctx or stopChannel firing. Returning here avoids
+				// busy-looping on the zero value a closed channel keeps
+				// yielding, which would otherwise peg a CPU core forever.
+				l.Warn("kafka consumer messages channel closed, stopping consume loop")
+				return nil
+			}
+			q.OffsetsChecker.MarkObserved(msg.Topic, msg.Partition, msg.Offset)
+			pending = append(pending, msg)
+		case err, ok := <-q.Consumer.Errors():
+			if ok {
+				l.WithError(err).Error("error in kafka consumer")
+			}
+		}
+
+		if len(pending) == 0 || !q.OffsetsChecker.Ready() {
+			continue
+		}
+
+		for _, msg := range pending {
+			select {
+			case q.msgChan <- interfaces.KafkaMessage{Game: msg.Topic, Value: msg.Value}:
+				q.Consumer.MarkOffset(msg, "")
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-q.stopChannel:
+				return nil
+			}
+		}
+		pending = pending[:0]
+	}
+}
+
+// StopConsuming stops consuming messages from the queue
+func (q *KafkaConsumer) StopConsuming() {
+	if !q.stopChannelClosed {
+		close(q.stopChannel)
+		q.stopChannelClosed = true
+	}
+	q.run = false
+}
+
+// MessagesChannel returns the channel that will receive new messages
+func (q *KafkaConsumer) MessagesChannel() *chan interfaces.KafkaMessage {
+	return &q.msgChan
+}
+
+// PendingMessagesWaitGroup returns the waitGroup that is incremented every time a new message arrives
+func (q *KafkaConsumer) PendingMessagesWaitGroup() *sync.WaitGroup {
+	return q.pendingMessagesWG
+}
+
+// Probe returns whether the consumer has caught up to the offsets that were
+// committed at the last partition assignment, so HTTP readiness checks can
+// reflect it without reaching into the offsets checker directly.
+func (q *KafkaConsumer) Probe() bool {
+	if q.OffsetsChecker == nil {
+		return true
+	}
+	return q.OffsetsChecker.Probe()
+}
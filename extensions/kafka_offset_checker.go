@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/Sirupsen/logrus"
+)
+
+// ConsumerGroupOffsetsChecker snapshots the committed offsets for the
+// partitions assigned to a consumer group on rebalance, and reports whether
+// the consumer has since observed an offset >= the committed one for every
+// assigned partition. It is used to gate message forwarding during
+// subscription/partition transitions, when a consumer can otherwise pull
+// messages before the rest of the pipeline is ready to handle them.
+type ConsumerGroupOffsetsChecker struct {
+	Logger        *logrus.Logger
+	Brokers       string
+	ConsumerGroup string
+
+	mu        sync.Mutex
+	committed map[string]map[int32]int64
+	observed  map[string]map[int32]int64
+	ready     bool
+	readyChan chan struct{}
+}
+
+// NewConsumerGroupOffsetsChecker creates a new ConsumerGroupOffsetsChecker
+func NewConsumerGroupOffsetsChecker(logger *logrus.Logger, brokers, consumerGroup string) *ConsumerGroupOffsetsChecker {
+	return &ConsumerGroupOffsetsChecker{
+		Logger:        logger,
+		Brokers:       brokers,
+		ConsumerGroup: consumerGroup,
+		committed:     map[string]map[int32]int64{},
+		observed:      map[string]map[int32]int64{},
+		readyChan:     make(chan struct{}),
+	}
+}
+
+// SnapshotCommittedOffsets queries the broker for the last committed offset
+// of every partition in assigned and stores it, resetting readiness until
+// MarkObserved catches every partition up again.
+func (c *ConsumerGroupOffsetsChecker) SnapshotCommittedOffsets(assigned map[string][]int32) {
+	l := c.Logger.WithFields(logrus.Fields{
+		"method":        "SnapshotCommittedOffsets",
+		"consumerGroup": c.ConsumerGroup,
+	})
+
+	admin, err := sarama.NewClusterAdmin([]string{c.Brokers}, nil)
+	if err != nil {
+		l.WithError(err).Error("failed to create kafka cluster admin to fetch committed offsets")
+		return
+	}
+	defer admin.Close()
+
+	offsets, err := admin.ListConsumerGroupOffsets(c.ConsumerGroup, assigned)
+	if err != nil {
+		l.WithError(err).Error("failed to list consumer group offsets")
+		return
+	}
+
+	client, err := sarama.NewClient([]string{c.Brokers}, nil)
+	if err != nil {
+		l.WithError(err).Error("failed to create kafka client to fetch partition high watermarks")
+		return
+	}
+	defer client.Close()
+
+	// Fetch every high watermark before taking c.mu, so a slow or large
+	// broker round-trip never blocks MarkObserved (called from the Kafka
+	// consumer goroutine) for the duration of the whole rebalance.
+	newest := map[string]map[int32]int64{}
+	for topic, block := range offsets.Blocks {
+		newest[topic] = map[int32]int64{}
+		for partition := range block {
+			if n, err := client.GetOffset(topic, partition, sarama.OffsetNewest); err == nil {
+				newest[topic][partition] = n
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed = map[string]map[int32]int64{}
+	c.observed = map[string]map[int32]int64{}
+	c.ready = false
+	c.readyChan = make(chan struct{})
+	for topic, block := range offsets.Blocks {
+		c.committed[topic] = map[int32]int64{}
+		c.observed[topic] = map[int32]int64{}
+		for partition, b := range block {
+			c.committed[topic][partition] = b.Offset
+			// A partition with no backlog (nothing produced past what was
+			// already committed) will never get a MarkObserved call, since
+			// no new message will ever arrive for it. Treat it as already
+			// caught up so it can't block readiness forever.
+			if n, ok := newest[topic][partition]; ok && b.Offset >= n {
+				c.observed[topic][partition] = b.Offset
+			}
+		}
+	}
+	c.recomputeReadyLocked()
+}
+
+// MarkObserved records that the consumer has seen offset for topic/partition,
+// and flips the checker ready once every assigned partition has caught up.
+func (c *ConsumerGroupOffsetsChecker) MarkObserved(topic string, partition int32, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.observed[topic] == nil {
+		c.observed[topic] = map[int32]int64{}
+	}
+	c.observed[topic][partition] = offset
+	c.recomputeReadyLocked()
+}
+
+func (c *ConsumerGroupOffsetsChecker) recomputeReadyLocked() {
+	if c.ready {
+		return
+	}
+	// No committed offsets means no partition assignment has been snapshotted
+	// yet, so there is nothing to have caught up to.
+	if len(c.committed) == 0 {
+		return
+	}
+	for topic, partitions := range c.committed {
+		for partition, committedOffset := range partitions {
+			if c.observed[topic][partition] < committedOffset {
+				return
+			}
+		}
+	}
+	c.ready = true
+	close(c.readyChan)
+}
+
+// Ready returns whether the consumer has caught up to the committed offsets
+// observed at the last partition assignment.
+func (c *ConsumerGroupOffsetsChecker) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready
+}
+
+// Wait blocks until the checker becomes ready or ctx is done, whichever
+// happens first. It is not used by ConsumeLoop (which must never block the
+// goroutine that feeds MarkObserved) but is available for callers that can
+// afford to block, e.g. tests and CLI tooling.
+func (c *ConsumerGroupOffsetsChecker) Wait(ctx context.Context) error {
+	c.mu.Lock()
+	ch := c.readyChan
+	ready := c.ready
+	c.mu.Unlock()
+	if ready {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Probe reports the current readiness of the offsets checker so HTTP health
+// endpoints can reflect whether the consumer has caught up after a rebalance.
+func (c *ConsumerGroupOffsetsChecker) Probe() bool {
+	return c.Ready()
+}
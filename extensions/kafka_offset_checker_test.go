@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("ConsumerGroupOffsetsChecker", func() {
+	logger, _ := test.NewNullLogger()
+
+	Describe("[Unit]", func() {
+		It("is not ready until every committed partition has been observed", func() {
+			c := NewConsumerGroupOffsetsChecker(logger, "brokers:9092", "group")
+			c.committed = map[string]map[int32]int64{"topic": {0: 10, 1: 20}}
+			c.observed = map[string]map[int32]int64{"topic": {}}
+			c.recomputeReadyLocked()
+			Expect(c.Ready()).To(BeFalse())
+
+			c.MarkObserved("topic", 0, 10)
+			Expect(c.Ready()).To(BeFalse())
+
+			c.MarkObserved("topic", 1, 20)
+			Expect(c.Ready()).To(BeTrue())
+		})
+
+		It("unblocks Wait once ready", func() {
+			c := NewConsumerGroupOffsetsChecker(logger, "brokers:9092", "group")
+			c.committed = map[string]map[int32]int64{"topic": {0: 5}}
+			c.observed = map[string]map[int32]int64{"topic": {}}
+			c.recomputeReadyLocked()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- c.Wait(context.Background())
+			}()
+
+			c.MarkObserved("topic", 0, 5)
+
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("returns ctx.Err() from Wait when ctx is done before ready", func() {
+			c := NewConsumerGroupOffsetsChecker(logger, "brokers:9092", "group")
+			c.committed = map[string]map[int32]int64{"topic": {0: 5}}
+			c.observed = map[string]map[int32]int64{"topic": {}}
+			c.recomputeReadyLocked()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			Expect(c.Wait(ctx)).To(Equal(context.DeadlineExceeded))
+		})
+
+		It("does not panic when readiness is reached across more than one rebalance", func() {
+			c := NewConsumerGroupOffsetsChecker(logger, "brokers:9092", "group")
+
+			// First rebalance: reset readiness and reach it.
+			c.mu.Lock()
+			c.committed = map[string]map[int32]int64{"topic": {0: 1}}
+			c.observed = map[string]map[int32]int64{"topic": {}}
+			c.ready = false
+			c.readyChan = make(chan struct{})
+			c.recomputeReadyLocked()
+			c.mu.Unlock()
+			c.MarkObserved("topic", 0, 1)
+			Expect(c.Ready()).To(BeTrue())
+
+			// Second rebalance: resetting readiness must allocate a fresh
+			// readyChan, otherwise reaching readiness again closes an
+			// already-closed channel and panics.
+			c.mu.Lock()
+			c.committed = map[string]map[int32]int64{"topic": {0: 2}}
+			c.observed = map[string]map[int32]int64{"topic": {}}
+			c.ready = false
+			c.readyChan = make(chan struct{})
+			c.mu.Unlock()
+
+			Expect(func() {
+				c.MarkObserved("topic", 0, 2)
+			}).NotTo(Panic())
+			Expect(c.Ready()).To(BeTrue())
+		})
+	})
+})
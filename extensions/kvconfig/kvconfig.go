@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package kvconfig adds an optional KV-backed configuration source (etcd or
+// Consul) on top of a static YAML config, so a whitelisted subset of keys
+// can be hot-reloaded without restarting the pusher.
+package kvconfig
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+	_ "github.com/spf13/viper/remote"
+)
+
+// HotReloadableKeys is the whitelist of config keys that are safe to apply
+// without a restart. Everything else (certificate path, kafka brokers, ...)
+// requires one, since it's only read once during wiring.
+var HotReloadableKeys = []string{
+	"apns.concurrentWorkers",
+	"apns.rateLimit",
+	"feedback.reporters",
+	"log.level",
+	"invalidToken.db.dsn",
+}
+
+// ConfigChange describes a single key that changed value in the KV store.
+type ConfigChange struct {
+	Key      string
+	OldValue interface{}
+	NewValue interface{}
+}
+
+// Watcher polls a KV-backed remote config source for changes and publishes
+// the hot-reloadable subset on Changes.
+type Watcher struct {
+	Logger  *logrus.Logger
+	Config  *viper.Viper
+	Changes chan ConfigChange
+
+	lastValues map[string]interface{}
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// New loads the initial config from the KV store configured under
+// `config.kv.*` and starts watching it for changes. It returns nil, nil
+// when `config.source` isn't "kv", so callers can unconditionally call this
+// and fall back to the static YAML config.
+func New(config *viper.Viper, logger *logrus.Logger) (*Watcher, error) {
+	if config.GetString("config.source") != "kv" {
+		return nil, nil
+	}
+
+	provider := config.GetString("config.kv.provider")
+	if provider == "" {
+		provider = "etcd3"
+	}
+	endpoints := config.GetString("config.kv.endpoints")
+	path := config.GetString("config.kv.path")
+	if path == "" {
+		path = "/pusher/config"
+	}
+
+	if err := config.AddRemoteProvider(provider, endpoints, path); err != nil {
+		return nil, err
+	}
+	config.SetConfigType("yaml")
+	if err := config.ReadRemoteConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read initial config from %s at %s: %w", provider, endpoints, err)
+	}
+
+	w := &Watcher{
+		Logger:     logger,
+		Config:     config,
+		Changes:    make(chan ConfigChange, 16),
+		lastValues: snapshot(config),
+		stop:       make(chan struct{}),
+	}
+	go w.watch()
+
+	return w, nil
+}
+
+// Stop terminates the watch goroutine. It is safe to call more than once
+// and safe to call on a nil Watcher, since New returns one whenever
+// `config.source` isn't "kv".
+func (w *Watcher) Stop() {
+	if w == nil {
+		return
+	}
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+func (w *Watcher) watch() {
+	l := w.Logger.WithFields(logrus.Fields{"method": "kvconfig.Watcher.watch"})
+	// w.watch is the only writer of w.Changes, so it's the one that closes
+	// it once it stops - otherwise applyKVConfigChanges's `range w.Changes`
+	// would block forever past Stop() instead of returning.
+	defer close(w.Changes)
+	for {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-w.stop:
+			return
+		}
+		if err := w.Config.WatchRemoteConfig(); err != nil {
+			l.WithError(err).Warn("failed to watch remote config")
+			continue
+		}
+		w.diffAndPublish()
+	}
+}
+
+func (w *Watcher) diffAndPublish() {
+	l := w.Logger.WithFields(logrus.Fields{"method": "kvconfig.Watcher.diffAndPublish"})
+	current := snapshot(w.Config)
+	for _, key := range HotReloadableKeys {
+		oldValue, newValue := w.lastValues[key], current[key]
+		if fmt.Sprintf("%v", oldValue) == fmt.Sprintf("%v", newValue) {
+			continue
+		}
+		l.WithField("key", key).Info("hot-reloadable config key changed")
+		w.Changes <- ConfigChange{Key: key, OldValue: oldValue, NewValue: newValue}
+	}
+	for _, key := range []string{"apns.certificatePath", "kafka.brokers"} {
+		oldValue, newValue := w.lastValues[key], current[key]
+		if fmt.Sprintf("%v", oldValue) != fmt.Sprintf("%v", newValue) {
+			l.WithField("key", key).Warn("config key changed but is not hot-reloadable, restart required")
+		}
+	}
+	w.lastValues = current
+}
+
+func snapshot(config *viper.Viper) map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, key := range HotReloadableKeys {
+		values[key] = config.Get(key)
+	}
+	values["apns.certificatePath"] = config.Get("apns.certificatePath")
+	values["kafka.brokers"] = config.Get("kafka.brokers")
+	return values
+}
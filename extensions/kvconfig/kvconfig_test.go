@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package kvconfig
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Watcher", func() {
+	logger, _ := test.NewNullLogger()
+
+	Describe("[Unit]", func() {
+		It("Stop terminates the watch goroutine instead of looping forever", func() {
+			w := &Watcher{
+				Logger:     logger,
+				Config:     viper.New(),
+				Changes:    make(chan ConfigChange, 1),
+				lastValues: map[string]interface{}{},
+				stop:       make(chan struct{}),
+			}
+			done := make(chan struct{})
+			go func() {
+				w.watch()
+				close(done)
+			}()
+
+			w.Stop()
+
+			Eventually(done, 2*time.Second).Should(BeClosed())
+		})
+
+		It("Stop is idempotent and safe on a nil Watcher", func() {
+			w := &Watcher{stop: make(chan struct{})}
+			Expect(func() {
+				w.Stop()
+				w.Stop()
+			}).NotTo(Panic())
+
+			var nilWatcher *Watcher
+			Expect(func() { nilWatcher.Stop() }).NotTo(Panic())
+		})
+	})
+})
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTLPReporter is a FeedbackReporter that emits a span per push attempt and
+// increments a counter metric per APNS response reason, exported over OTLP
+// to the endpoint it was created with. Unlike relying on the global
+// otel.Tracer/otel.Meter, it owns its own TracerProvider/MeterProvider so
+// spans and metrics are guaranteed to actually leave the process instead of
+// silently landing on a no-op global provider.
+type OTLPReporter struct {
+	Logger         *logrus.Logger
+	tracerProvider *sdktrace.TracerProvider
+	meterProvider  *sdkmetric.MeterProvider
+	tracer         trace.Tracer
+	reasonCounter  metric.Int64Counter
+}
+
+// NewOTLPReporter creates a new OTLPReporter exporting spans and metrics
+// over OTLP/gRPC to endpoint.
+func NewOTLPReporter(endpoint string, logger *logrus.Logger) (*OTLPReporter, error) {
+	ctx := context.Background()
+
+	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter))
+
+	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpoint(endpoint), otlpmetricgrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+
+	counter, err := meterProvider.Meter("github.com/topfreegames/pusher").Int64Counter("apns_response_reason_total")
+	if err != nil {
+		return nil, err
+	}
+
+	return &OTLPReporter{
+		Logger:         logger,
+		tracerProvider: tracerProvider,
+		meterProvider:  meterProvider,
+		tracer:         tracerProvider.Tracer("github.com/topfreegames/pusher"),
+		reasonCounter:  counter,
+	}, nil
+}
+
+// SendNotification starts a span for the push attempt encoded in msg and
+// increments the reason counter.
+func (o *OTLPReporter) SendNotification(msg []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, span := o.tracer.Start(ctx, "apns.push")
+	defer span.End()
+
+	reason, _ := fields["reason"].(string)
+	span.SetAttributes(attribute.String("apns.reason", reason))
+	o.reasonCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+
+	return nil
+}
+
+// Ping always returns nil; it does not check whether the OTLP endpoint is
+// reachable.
+func (o *OTLPReporter) Ping() error {
+	return nil
+}
+
+// Flush forces the tracer and meter providers to export whatever spans and
+// metrics are buffered, and shuts the exporter pipeline down. Called by
+// flushFeedbackReporters on graceful shutdown and on hot config reload.
+func (o *OTLPReporter) Flush() {
+	ctx := context.Background()
+	if o.tracerProvider != nil {
+		if err := o.tracerProvider.Shutdown(ctx); err != nil {
+			o.Logger.WithError(err).Error("failed to shut down OTLP tracer provider, buffered spans may have been dropped")
+		}
+	}
+	if o.meterProvider != nil {
+		if err := o.meterProvider.Shutdown(ctx); err != nil {
+			o.Logger.WithError(err).Error("failed to shut down OTLP meter provider, buffered metrics may have been dropped")
+		}
+	}
+}
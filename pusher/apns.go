@@ -23,36 +23,21 @@
 package pusher
 
 import (
-	"os"
-	"os/signal"
-	"runtime"
+	"context"
 	"sync"
-	"syscall"
-	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/spf13/viper"
 	"github.com/topfreegames/pusher/extensions"
 	"github.com/topfreegames/pusher/interfaces"
 	"github.com/topfreegames/pusher/util"
 )
 
-// APNSPusher struct for apns pusher
+// APNSPusher struct for apns pusher. The lifecycle, healthcheck and
+// KV-config hot-reload machinery it shares with GCMPusher lives in base,
+// see base.go.
 type APNSPusher struct {
-	AppName                 string
-	CertificatePath         string
-	Config                  *viper.Viper
-	ConfigFile              string
-	feedbackReporters       []interfaces.FeedbackReporter
-	GracefulShutdownTimeout int
-	InvalidTokenHandlers    []interfaces.InvalidTokenHandler
-	IsProduction            bool
-	Logger                  *logrus.Logger
-	MessageHandler          interfaces.MessageHandler
-	PendingMessagesWG       *sync.WaitGroup
-	Queue                   interfaces.Queue
-	run                     bool
-	StatsReporters          []interfaces.StatsReporter
+	base
+	CertificatePath string
 }
 
 // NewAPNSPusher for getting a new APNSPusher instance
@@ -67,12 +52,14 @@ func NewAPNSPusher(configFile,
 ) (*APNSPusher, error) {
 	var wg sync.WaitGroup
 	a := &APNSPusher{
-		AppName:           appName,
-		CertificatePath:   certificatePath,
-		ConfigFile:        configFile,
-		IsProduction:      isProduction,
-		Logger:            logger,
-		PendingMessagesWG: &wg,
+		base: base{
+			AppName:           appName,
+			ConfigFile:        configFile,
+			IsProduction:      isProduction,
+			Logger:            logger,
+			PendingMessagesWG: &wg,
+		},
+		CertificatePath: certificatePath,
 	}
 	var queue interfaces.APNSPushQueue
 	if len(queueOrNil) > 0 {
@@ -87,12 +74,21 @@ func NewAPNSPusher(configFile,
 
 func (a *APNSPusher) loadConfigurationDefaults() {
 	a.Config.SetDefault("gracefulShutdownTimeout", 10)
+	a.Config.SetDefault("healthcheck.port", 8080)
 }
 
 func (a *APNSPusher) configure(queue interfaces.APNSPushQueue, db interfaces.DB, statsReporters []interfaces.StatsReporter) error {
 	a.Config = util.NewViperWithConfigFile(a.ConfigFile)
+	// configureKVConfig must run before anything below reads a.Config, so
+	// the initial KV-store load (when config.source is "kv") is in place
+	// for every component built from it, instead of being loaded too late
+	// to matter and silently ignored.
+	if err := a.configureKVConfig(); err != nil {
+		return err
+	}
 	a.loadConfigurationDefaults()
 	a.GracefulShutdownTimeout = a.Config.GetInt("gracefulShutdownTimeout")
+	a.HealthcheckPort = a.Config.GetInt("healthcheck.port")
 	if err := a.configureStatsReporters(statsReporters); err != nil {
 		return err
 	}
@@ -113,6 +109,9 @@ func (a *APNSPusher) configure(queue interfaces.APNSPushQueue, db interfaces.DB,
 		a.Logger,
 		a.Queue.PendingMessagesWaitGroup(),
 		a.StatsReporters,
+		// a.feedbackReporters is a registry, not a plain slice, so a hot
+		// reload of `feedback.reporters` is visible to every SendNotification
+		// call the handler makes, not just the set captured here at startup.
 		a.feedbackReporters,
 		a.InvalidTokenHandlers,
 		queue,
@@ -121,80 +120,23 @@ func (a *APNSPusher) configure(queue interfaces.APNSPushQueue, db interfaces.DB,
 		return err
 	}
 	a.MessageHandler = handler
+	// Only start consuming KV changes once every field applyKVConfigChanges
+	// touches (MessageHandler, feedbackReporters, InvalidTokenHandlers) is
+	// wired, since a hot reload could otherwise race their construction.
+	a.startKVConfigWatcher()
 	return nil
 }
 
-func (a *APNSPusher) configureFeedbackReporters() error {
-	reporters, err := configureFeedbackReporters(a.ConfigFile, a.Logger, a.Config)
-	if err != nil {
-		return err
-	}
-	a.feedbackReporters = reporters
-	return nil
-}
-
-func (a *APNSPusher) configureStatsReporters(statsReporters []interfaces.StatsReporter) error {
-	if statsReporters != nil {
-		a.StatsReporters = statsReporters
-		return nil
-	}
-	reporters, err := configureStatsReporters(a.ConfigFile, a.Logger, a.AppName, a.Config)
-	if err != nil {
-		return err
-	}
-	a.StatsReporters = reporters
-	return nil
-}
-
-func (a *APNSPusher) configureInvalidTokenHandlers(dbOrNil interfaces.DB) error {
-	invalidTokenHandlers, err := configureInvalidTokenHandlers(a.Config, a.Logger, dbOrNil)
-	if err != nil {
-		return err
-	}
-	a.InvalidTokenHandlers = invalidTokenHandlers
-	return nil
-}
-
-// Start starts pusher in apns mode
+// Start starts pusher in apns mode, owning its own signal handling. It is
+// equivalent to calling StartWithContext with a context that is canceled on
+// SIGINT/SIGTERM.
 func (a *APNSPusher) Start() {
-	a.run = true
-	l := a.Logger.WithFields(logrus.Fields{
-		"method":          "start",
-		"configFile":      a.ConfigFile,
-		"certificatePath": a.CertificatePath,
-	})
-	l.Info("starting pusher in apns mode...")
-	go a.MessageHandler.HandleMessages(a.Queue.MessagesChannel())
-	go a.MessageHandler.HandleResponses()
-	go a.Queue.ConsumeLoop()
-	go a.reportGoStats()
-	sigchan := make(chan os.Signal)
-	signal.Notify(sigchan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-
-	for a.run == true {
-		select {
-		case sig := <-sigchan:
-			l.Warnf("caught signal %v: terminating\n", sig)
-			a.run = false
-		}
-	}
-	a.Queue.StopConsuming()
-	GracefulShutdown(a.Queue.PendingMessagesWaitGroup(), time.Duration(a.GracefulShutdownTimeout)*time.Second)
+	a.run("apns")
 }
 
-func (a *APNSPusher) reportGoStats() {
-	for {
-		num := runtime.NumGoroutine()
-		m := &runtime.MemStats{}
-		runtime.ReadMemStats(m)
-		gcTime := m.PauseNs[(m.NumGC+255)%256]
-		for _, statsReporter := range a.StatsReporters {
-			statsReporter.ReportGoStats(
-				num,
-				m.Alloc, m.HeapObjects, m.NextGC,
-				gcTime,
-			)
-		}
-		time.Sleep(30 * time.Second)
-	}
+// StartWithContext starts pusher in apns mode bound to ctx, so it can be
+// embedded in a larger process that owns its own signal handling instead of
+// the pusher owning it. See base.runWithContext for the shutdown ordering.
+func (a *APNSPusher) StartWithContext(ctx context.Context) error {
+	return a.runWithContext(ctx, "apns")
 }
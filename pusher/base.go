@@ -0,0 +1,338 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/extensions/kvconfig"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// base holds the lifecycle, healthcheck, and KV-config hot-reload machinery
+// shared by every concrete pusher (APNSPusher, GCMPusher), so adding a new
+// push channel means plugging in its own message handler/queue
+// construction, not re-deriving shutdown ordering and health endpoints.
+type base struct {
+	AppName                 string
+	Config                  *viper.Viper
+	ConfigFile              string
+	feedbackReporters       *extensions.FeedbackReporterRegistry
+	GracefulShutdownTimeout int
+	healthcheck             *healthcheckServer
+	HealthcheckPort         int
+	InvalidTokenHandlers    []interfaces.InvalidTokenHandler
+	IsProduction            bool
+	Logger                  *logrus.Logger
+	MessageHandler          interfaces.MessageHandler
+	PendingMessagesWG       *sync.WaitGroup
+	Queue                   interfaces.Queue
+	ctx                     context.Context
+	cancel                  context.CancelFunc
+	kvConfigWatcher         *kvconfig.Watcher
+	StatsReporters          []interfaces.StatsReporter
+}
+
+// configureKVConfig optionally wires up a KV-backed config source (etcd or
+// Consul, see extensions/kvconfig). When `config.source` isn't "kv" this is
+// a no-op, so the pusher keeps reading the static YAML config as before. It
+// only loads the initial config and stores the watcher; callers start
+// consuming its Changes channel with startKVConfigWatcher once the rest of
+// the pusher is wired.
+func (b *base) configureKVConfig() error {
+	watcher, err := kvconfig.New(b.Config, b.Logger)
+	if err != nil {
+		return err
+	}
+	b.kvConfigWatcher = watcher
+	return nil
+}
+
+// startKVConfigWatcher starts consuming KV config changes. Call it only
+// once MessageHandler, feedbackReporters and InvalidTokenHandlers are all
+// wired, since applyKVConfigChanges reads every one of them and a hot
+// reload could otherwise race their construction.
+func (b *base) startKVConfigWatcher() {
+	if b.kvConfigWatcher != nil {
+		go b.applyKVConfigChanges(b.kvConfigWatcher.Changes)
+	}
+}
+
+// resizer is implemented by message handlers that can resize their worker
+// pool without a restart.
+type resizer interface {
+	Resize(workers int)
+}
+
+// rateLimiter is implemented by message handlers that can apply a new push
+// rate limit without a restart.
+type rateLimiter interface {
+	SetRateLimit(rate int)
+}
+
+// dsnReconfigurer is implemented by invalid token handlers that can swap
+// their DB connection string without a restart.
+type dsnReconfigurer interface {
+	Reconfigure(dsn string) error
+}
+
+// applyKVConfigChanges hot-applies the whitelisted config keys published by
+// the KV config watcher. Keys outside the whitelist never reach here, see
+// kvconfig.HotReloadableKeys. apns.concurrentWorkers and apns.rateLimit are
+// resolved by duck-typing MessageHandler against resizer/rateLimiter, so a
+// pusher whose handler doesn't implement either (e.g. GCM's) is correctly a
+// no-op instead of needing its own copy of this switch.
+func (b *base) applyKVConfigChanges(changes <-chan kvconfig.ConfigChange) {
+	l := b.Logger.WithFields(logrus.Fields{"method": "applyKVConfigChanges"})
+	for change := range changes {
+		switch change.Key {
+		case "apns.concurrentWorkers":
+			workers, ok := change.NewValue.(int)
+			if !ok {
+				l.WithField("value", change.NewValue).Warn("ignoring non-integer apns.concurrentWorkers")
+				continue
+			}
+			if r, ok := b.MessageHandler.(resizer); ok {
+				r.Resize(workers)
+			}
+		case "log.level":
+			if level, err := logrus.ParseLevel(fmt.Sprintf("%v", change.NewValue)); err == nil {
+				b.Logger.Level = level
+			}
+		case "feedback.reporters":
+			reporters, err := configureFeedbackReporters(b.ConfigFile, b.Logger, b.Config)
+			if err != nil {
+				l.WithError(err).Error("failed to reconfigure feedback reporters")
+				continue
+			}
+			// Swap atomically so the running message handler's next
+			// SendNotification call picks up the new reporters, then flush
+			// the replaced ones so their buffer goroutines and client
+			// connections don't leak.
+			old := b.feedbackReporters.Set(reporters)
+			flushFeedbackReporters(old)
+		case "apns.rateLimit":
+			rate, ok := change.NewValue.(int)
+			if !ok {
+				l.WithField("value", change.NewValue).Warn("ignoring non-integer apns.rateLimit")
+				continue
+			}
+			if r, ok := b.MessageHandler.(rateLimiter); ok {
+				r.SetRateLimit(rate)
+			} else {
+				l.Debug("apns.rateLimit changed but the message handler does not support live rate limit updates")
+			}
+		case "invalidToken.db.dsn":
+			dsn := fmt.Sprintf("%v", change.NewValue)
+			applied := false
+			for _, handler := range b.InvalidTokenHandlers {
+				if r, ok := handler.(dsnReconfigurer); ok {
+					if err := r.Reconfigure(dsn); err != nil {
+						l.WithError(err).Error("failed to reconfigure invalid token handler with new DSN")
+						continue
+					}
+					applied = true
+				}
+			}
+			if !applied {
+				l.Warn("invalidToken.db.dsn changed but no invalid token handler supports live DSN updates, restart required")
+			}
+		default:
+			l.WithField("key", change.Key).Debug("applied hot-reloadable config change")
+		}
+	}
+}
+
+func (b *base) configureFeedbackReporters() error {
+	reporters, err := configureFeedbackReporters(b.ConfigFile, b.Logger, b.Config)
+	if err != nil {
+		return err
+	}
+	b.feedbackReporters = extensions.NewFeedbackReporterRegistry(reporters)
+	return nil
+}
+
+func (b *base) configureStatsReporters(statsReporters []interfaces.StatsReporter) error {
+	if statsReporters != nil {
+		b.StatsReporters = statsReporters
+		return nil
+	}
+	reporters, err := configureStatsReporters(b.ConfigFile, b.Logger, b.AppName, b.Config)
+	if err != nil {
+		return err
+	}
+	b.StatsReporters = reporters
+	return nil
+}
+
+func (b *base) configureInvalidTokenHandlers(dbOrNil interfaces.DB) error {
+	invalidTokenHandlers, err := configureInvalidTokenHandlers(b.Config, b.Logger, dbOrNil)
+	if err != nil {
+		return err
+	}
+	b.InvalidTokenHandlers = invalidTokenHandlers
+	return nil
+}
+
+// run starts the pusher in the given mode ("apns", "gcm", ...), owning its
+// own signal handling. It is equivalent to calling runWithContext with a
+// context that is canceled on SIGINT/SIGTERM.
+func (b *base) run(mode string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigchan
+		b.Logger.Warnf("caught signal %v: terminating\n", sig)
+		cancel()
+	}()
+
+	b.runWithContext(ctx, mode)
+}
+
+// runWithContext starts the pusher in the given mode bound to ctx, so it
+// can be embedded in a larger process that owns its own signal handling
+// instead of the pusher owning it. Shutdown happens in this order once ctx
+// is canceled: (1) /readyz starts answering 503, (2) the consumer stops
+// pulling, (3) pending messages are drained up to GracefulShutdownTimeout,
+// (4) the KV config watcher is stopped, (5) feedback reporters are flushed.
+// The messages channel is not closed; the handler goroutines stop on ctx.
+func (b *base) runWithContext(ctx context.Context, mode string) error {
+	b.ctx, b.cancel = context.WithCancel(ctx)
+	l := b.Logger.WithFields(logrus.Fields{
+		"method":     "start",
+		"configFile": b.ConfigFile,
+	})
+	l.Infof("starting pusher in %s mode...", mode)
+	go b.MessageHandler.HandleMessages(b.ctx, b.Queue.MessagesChannel())
+	go b.MessageHandler.HandleResponses(b.ctx)
+	go b.Queue.ConsumeLoop(b.ctx)
+	go b.reportGoStats(b.ctx)
+
+	b.healthcheck = newHealthcheckServer(b.HealthcheckPort, b.Logger, b.isAlive, b.isReady, b.healthcheckComponents)
+	b.healthcheck.Start()
+
+	<-b.ctx.Done()
+	l.Info("context canceled, shutting down...")
+	b.healthcheck.Drain()
+
+	b.Queue.StopConsuming()
+	GracefulShutdown(b.Queue.PendingMessagesWaitGroup(), time.Duration(b.GracefulShutdownTimeout)*time.Second)
+
+	if b.kvConfigWatcher != nil {
+		b.kvConfigWatcher.Stop()
+	}
+	flushFeedbackReporters(b.feedbackReporters.Get())
+
+	return nil
+}
+
+// flusher is implemented by feedback reporters that buffer notifications and
+// need to drain them before shutdown completes.
+type flusher interface {
+	Flush()
+}
+
+// flushFeedbackReporters flushes every reporter that supports it. Used both
+// on graceful shutdown and to reclaim the reporters replaced by a hot
+// `feedback.reporters` config reload, so their buffer goroutines and client
+// connections don't leak.
+func flushFeedbackReporters(reporters []interfaces.FeedbackReporter) {
+	for _, reporter := range reporters {
+		if f, ok := reporter.(flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// isAlive reports whether the pusher's context is still live. It does not
+// check whether the handler goroutines are still running. Backs the /healthz
+// endpoint.
+func (b *base) isAlive() bool {
+	return b.ctx != nil && b.ctx.Err() == nil
+}
+
+// isReady reports whether the Kafka consumer has an assigned partition set
+// and has caught up to the offsets committed at assignment time. Backs the
+// /readyz endpoint.
+func (b *base) isReady() bool {
+	p, ok := b.Queue.(probeable)
+	if !ok {
+		return true
+	}
+	return p.Probe()
+}
+
+// healthcheckComponents returns the per-component sub-checks exposed at
+// /healthz?verbose=1. It is passed to newHealthcheckServer as a func value,
+// not called once and snapshotted, so a feedback.reporters hot reload is
+// reflected on the very next healthcheck request instead of pinging
+// reporters that were already swapped out and flushed.
+func (b *base) healthcheckComponents() map[string]interface{} {
+	components := map[string]interface{}{}
+	for i, reporter := range b.feedbackReporters.Get() {
+		components[fmt.Sprintf("feedbackReporter[%d]", i)] = reporter
+	}
+	for i, handler := range b.InvalidTokenHandlers {
+		components[fmt.Sprintf("invalidTokenHandler[%d]", i)] = handler
+	}
+	return components
+}
+
+func (b *base) reportGoStats(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		num := runtime.NumGoroutine()
+		m := &runtime.MemStats{}
+		runtime.ReadMemStats(m)
+		gcTime := m.PauseNs[(m.NumGC+255)%256]
+		for _, statsReporter := range b.StatsReporters {
+			statsReporter.ReportGoStats(
+				num,
+				m.Alloc, m.HeapObjects, m.NextGC,
+				gcTime,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
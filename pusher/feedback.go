@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+const defaultFeedbackReporterBufferSize = 1000
+
+// configureFeedbackReporters builds the list of feedback reporters declared
+// under the `feedback.reporters` config key. Each entry is wrapped in a
+// extensions.BufferedFeedbackReporter so a slow sink drops its own
+// notifications instead of back-pressuring the caller.
+func configureFeedbackReporters(configFile string, logger *logrus.Logger, config *viper.Viper) ([]interfaces.FeedbackReporter, error) {
+	var rawReporters []map[string]interface{}
+	if err := config.UnmarshalKey("feedback.reporters", &rawReporters); err != nil {
+		return nil, err
+	}
+
+	bufferSize := config.GetInt("feedback.bufferSize")
+	if bufferSize == 0 {
+		bufferSize = defaultFeedbackReporterBufferSize
+	}
+
+	reporters := []interfaces.FeedbackReporter{}
+	for i, raw := range rawReporters {
+		reporterType, _ := raw["type"].(string)
+		reporter, err := newFeedbackReporter(reporterType, raw, config, logger)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%s[%d]", reporterType, i)
+		reporters = append(reporters, extensions.NewBufferedFeedbackReporter(name, reporter, bufferSize, logger))
+	}
+
+	return reporters, nil
+}
+
+func newFeedbackReporter(reporterType string, raw map[string]interface{}, config *viper.Viper, logger *logrus.Logger) (interfaces.FeedbackReporter, error) {
+	switch reporterType {
+	case "kafka":
+		return extensions.NewKafkaProducer(config, logger)
+	case "appinsights":
+		instrumentationKey, _ := raw["instrumentationKey"].(string)
+		return extensions.NewAppInsightsReporter(instrumentationKey, logger), nil
+	case "otlp":
+		endpoint, _ := raw["endpoint"].(string)
+		return extensions.NewOTLPReporter(endpoint, logger)
+	default:
+		return nil, fmt.Errorf("unknown feedback reporter type: %s", reporterType)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/util"
+)
+
+// GCMPusher struct for gcm pusher. The lifecycle, healthcheck and
+// KV-config hot-reload machinery it shares with APNSPusher lives in base,
+// see base.go.
+type GCMPusher struct {
+	base
+	APIKey string
+}
+
+// NewGCMPusher for getting a new GCMPusher instance
+func NewGCMPusher(configFile,
+	apiKey,
+	appName string,
+	isProduction bool,
+	logger *logrus.Logger,
+	statsReporters []interfaces.StatsReporter,
+	db interfaces.DB,
+	queueOrNil ...interfaces.GCMPushQueue,
+) (*GCMPusher, error) {
+	var wg sync.WaitGroup
+	g := &GCMPusher{
+		base: base{
+			AppName:           appName,
+			ConfigFile:        configFile,
+			IsProduction:      isProduction,
+			Logger:            logger,
+			PendingMessagesWG: &wg,
+		},
+		APIKey: apiKey,
+	}
+	var queue interfaces.GCMPushQueue
+	if len(queueOrNil) > 0 {
+		queue = queueOrNil[0]
+	}
+	err := g.configure(queue, db, statsReporters)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *GCMPusher) loadConfigurationDefaults() {
+	g.Config.SetDefault("gracefulShutdownTimeout", 10)
+	g.Config.SetDefault("healthcheck.port", 8081)
+}
+
+func (g *GCMPusher) configure(queue interfaces.GCMPushQueue, db interfaces.DB, statsReporters []interfaces.StatsReporter) error {
+	g.Config = util.NewViperWithConfigFile(g.ConfigFile)
+	// configureKVConfig must run before anything below reads g.Config, so
+	// the initial KV-store load (when config.source is "kv") is in place
+	// for every component built from it, instead of being loaded too late
+	// to matter and silently ignored.
+	if err := g.configureKVConfig(); err != nil {
+		return err
+	}
+	g.loadConfigurationDefaults()
+	g.GracefulShutdownTimeout = g.Config.GetInt("gracefulShutdownTimeout")
+	g.HealthcheckPort = g.Config.GetInt("healthcheck.port")
+	if err := g.configureStatsReporters(statsReporters); err != nil {
+		return err
+	}
+	if err := g.configureFeedbackReporters(); err != nil {
+		return err
+	}
+	if err := g.configureInvalidTokenHandlers(db); err != nil {
+		return err
+	}
+	q, err := extensions.NewKafkaConsumer(g.Config, g.Logger)
+	if err != nil {
+		return err
+	}
+	g.Queue = q
+	handler, err := extensions.NewGCMMessageHandler(
+		g.ConfigFile, g.APIKey, g.AppName,
+		g.IsProduction,
+		g.Logger,
+		g.Queue.PendingMessagesWaitGroup(),
+		g.StatsReporters,
+		// g.feedbackReporters is a registry, not a plain slice, for the same
+		// reason as APNSPusher: a feedback.reporters hot reload must be
+		// visible to every SendNotification call the handler makes.
+		g.feedbackReporters,
+		g.InvalidTokenHandlers,
+		queue,
+	)
+	if err != nil {
+		return err
+	}
+	g.MessageHandler = handler
+	// Only start consuming KV changes once every field applyKVConfigChanges
+	// touches (MessageHandler, feedbackReporters, InvalidTokenHandlers) is
+	// wired, since a hot reload could otherwise race their construction.
+	g.startKVConfigWatcher()
+	return nil
+}
+
+// Start starts pusher in gcm mode, owning its own signal handling. It is
+// equivalent to calling StartWithContext with a context that is canceled on
+// SIGINT/SIGTERM.
+func (g *GCMPusher) Start() {
+	g.run("gcm")
+}
+
+// StartWithContext starts pusher in gcm mode bound to ctx, so it can be
+// embedded in a larger process that owns its own signal handling instead of
+// the pusher owning it. See base.runWithContext for the shutdown ordering.
+func (g *GCMPusher) StartWithContext(ctx context.Context) error {
+	return g.runWithContext(ctx, "gcm")
+}
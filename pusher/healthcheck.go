@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// probeable is implemented by queues that can report whether they have
+// caught up to the offsets committed at the last partition assignment.
+type probeable interface {
+	Probe() bool
+}
+
+// pinger is implemented by feedback reporters and DBs that can report
+// whether they are reachable.
+type pinger interface {
+	Ping() error
+}
+
+// healthcheckServer serves /healthz and /readyz for a pusher instance. It
+// reflects process liveness and Kafka consumer readiness so the pusher can
+// be run behind orchestrator probes instead of relying purely on
+// process-alive signals.
+type healthcheckServer struct {
+	Logger *logrus.Logger
+	Port   int
+
+	server *http.Server
+
+	isAlive    func() bool
+	isReady    func() bool
+	draining   int32 // set/read via sync/atomic, written from the shutdown goroutine and read from HTTP handlers
+	components func() map[string]interface{}
+}
+
+func newHealthcheckServer(port int, logger *logrus.Logger, isAlive, isReady func() bool, components func() map[string]interface{}) *healthcheckServer {
+	return &healthcheckServer{
+		Logger:     logger,
+		Port:       port,
+		isAlive:    isAlive,
+		isReady:    isReady,
+		components: components,
+	}
+}
+
+// Start starts serving /healthz and /readyz in a goroutine.
+func (h *healthcheckServer) Start() {
+	l := h.Logger.WithFields(logrus.Fields{
+		"method": "healthcheckServer.Start",
+		"port":   h.Port,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
+	h.server = &http.Server{Addr: fmt.Sprintf(":%d", h.Port), Handler: mux}
+
+	go func() {
+		if err := h.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			l.WithError(err).Error("healthcheck server stopped unexpectedly")
+		}
+	}()
+}
+
+// Drain flips /readyz to 503 so orchestrators stop sending traffic before
+// GracefulShutdown starts draining in-flight messages.
+func (h *healthcheckServer) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+func (h *healthcheckServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	alive := h.isAlive()
+	if r.URL.Query().Get("verbose") == "1" {
+		statuses := map[string]string{}
+		for name, component := range h.components() {
+			statuses[name] = h.componentStatus(component)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !alive {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(statuses)
+		return
+	}
+	if !alive {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *healthcheckServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.draining) == 1 || !h.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *healthcheckServer) componentStatus(component interface{}) string {
+	p, ok := component.(pinger)
+	if !ok {
+		return "unknown"
+	}
+	if err := p.Ping(); err != nil {
+		return "down"
+	}
+	return "up"
+}